@@ -0,0 +1,51 @@
+// Copyright 2019 Gary Rong
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// zipfEntries returns n entries with weights drawn from a Zipfian
+// distribution, approximating the long-tailed weight distributions (e.g.
+// large staking sets) that motivated making MaxLevel configurable.
+func zipfEntries(n int) []*Entry {
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, 1.5, 1, uint64(100*n))
+	entries := make([]*Entry, n)
+	for i := range entries {
+		entries[i] = &Entry{Value: []byte(fmt.Sprintf("entry-%d", i)), Weight: z.Uint64() + 1}
+	}
+	return entries
+}
+
+func BenchmarkNewMerkleTree100kZipf(b *testing.B) {
+	entries := zipfEntries(100_000)
+	cfg := Config{MaxLevel: 32, MaxWeight: 1 << 62}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewMerkleTree(entries, WithConfig(cfg)); err != nil {
+			b.Fatalf("failed to build tree: %v", err)
+		}
+	}
+}
+
+func BenchmarkNewMerkleTree100kUniform(b *testing.B) {
+	entries := make([]*Entry, 100_000)
+	for i := range entries {
+		entries[i] = &Entry{Value: []byte(fmt.Sprintf("entry-%d", i)), Weight: 1}
+	}
+	// Equal weights need ceil(log2(100_000)) = 17 levels to represent, which
+	// exceeds the default MaxLevel of 10, so widen it the same way the Zipf
+	// benchmark does.
+	cfg := Config{MaxLevel: 32, MaxWeight: 1 << 62}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewMerkleTree(entries, WithConfig(cfg)); err != nil {
+			b.Fatalf("failed to build tree: %v", err)
+		}
+	}
+}
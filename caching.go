@@ -0,0 +1,358 @@
+// Copyright 2019 Gary Rong
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+// ErrSoleEntry is returned by CachingTree.Remove if the caller tries to
+// remove the last remaining entry, which NewMerkleTree doesn't allow.
+var ErrSoleEntry = errors.New("cannot remove the only remaining entry")
+
+// CachingTree wraps a MerkleTree and supports incrementally updating the
+// entry set without paying the full cost of NewMerkleTree - re-sorting,
+// re-leveling and re-hashing every entry - on every change.
+//
+// The tree shape is derived from a weight-normalization pass over the whole
+// entry set, so in principle a single Insert/Remove/UpdateWeight can perturb
+// every entry's Level. Rebuilding it from scratch every time defeats the
+// purpose of caching, so CachingTree instead applies a local rebalancing
+// policy: Insert and Remove only touch the spine between the affected leaf
+// and the root (splitting or collapsing one parent node), and UpdateWeight
+// doesn't touch the shape at all, since Entry.Hash never depends on Weight.
+// The resulting shape is only an approximation of the one a full rebuild
+// would produce; CachingTree tracks how much accumulated |Weight| drift that
+// approximation represents and forces a full rebuild once it exceeds
+// Epsilon, expressed as a fraction of the tree's total weight.
+type CachingTree struct {
+	tree    *MerkleTree
+	entries []*Entry
+	opts    []Option
+
+	total   uint64  // sum of every current entry's Weight, kept up to date by every update
+	drift   uint64  // sum of |Weight changes| applied locally since the last shape (re)build
+	Epsilon float64 // maximum drift/total ratio tolerated before a full rebuild is forced
+}
+
+// NewCachingTree constructs a CachingTree from the given entries. Epsilon
+// bounds how far Insert/Remove/UpdateWeight are allowed to locally perturb the tree's
+// weight distribution, as a fraction of total weight, before a full
+// NewMerkleTree rebuild is triggered.
+func NewCachingTree(entries []*Entry, epsilon float64, opts ...Option) (*CachingTree, error) {
+	tree, err := NewMerkleTree(entries, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var total uint64
+	for _, e := range entries {
+		total += e.Weight
+	}
+	return &CachingTree{
+		tree:    tree,
+		entries: append([]*Entry{}, entries...),
+		opts:    opts,
+		total:   total,
+		Epsilon: epsilon,
+	}, nil
+}
+
+// Tree returns the underlying MerkleTree as of the most recent update.
+func (c *CachingTree) Tree() *MerkleTree { return c.tree }
+
+// Insert adds a new entry to the tree. The new leaf is created by splitting
+// the shallowest (i.e. largest-weight-share) existing leaf in two, so the
+// new entry is given a reasonably large share rather than an infinitesimal
+// one; only the hashes on the spine above the split are invalidated.
+func (c *CachingTree) Insert(entry *Entry) error {
+	if entry.Weight == 0 {
+		return ErrInvalidWeight
+	}
+	var target *Node
+	for _, n := range c.tree.Leaves {
+		if target == nil || n.Level < target.Level {
+			target = n
+		}
+	}
+	level := target.Level + 1
+	if int(level) > c.tree.MaxLevel {
+		return fmt.Errorf("%w: inserting %x would need level %d which exceeds MaxLevel %d", ErrInvalidWeight, entry.Value, level, c.tree.MaxLevel)
+	}
+	c.entries = append(c.entries, entry)
+	c.total += entry.Weight
+	c.drift += entry.Weight
+
+	moved := &Node{Value: target.Value, Level: level}
+	target.Value.Level = level
+	leaf := &Node{Value: entry, Level: level}
+	entry.Level = level
+	parent := &Node{Left: moved, Right: leaf, Level: target.Level, Parent: target.Parent}
+	moved.Parent, leaf.Parent = parent, parent
+
+	switch {
+	case target.Parent == nil:
+		c.tree.Root = parent
+	case target.Parent.Left == target:
+		target.Parent.Left = parent
+	default:
+		target.Parent.Right = parent
+	}
+	for n := parent; n != nil; n = n.Parent {
+		n.Nodehash = nil
+	}
+	for i, n := range c.tree.Leaves {
+		if n == target {
+			c.tree.Leaves[i] = moved
+			break
+		}
+	}
+	c.tree.Leaves = append(c.tree.Leaves, leaf)
+
+	return c.maybeRebuild()
+}
+
+// Remove deletes the entry with the given value. The leaf's sibling is
+// promoted to take its parent's place, so only the hashes on the spine
+// above the removed leaf are invalidated.
+func (c *CachingTree) Remove(value []byte) error {
+	idx := -1
+	for i, e := range c.entries {
+		if bytes.Equal(e.Value, value) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return ErrUnknownEntry
+	}
+	if len(c.entries) == 1 {
+		return ErrSoleEntry
+	}
+	removed := c.entries[idx]
+	c.entries = append(c.entries[:idx], c.entries[idx+1:]...)
+	c.total -= removed.Weight
+	c.drift += removed.Weight
+
+	var target *Node
+	for _, n := range c.tree.Leaves {
+		if n.Value == removed {
+			target = n
+			break
+		}
+	}
+	parent := target.Parent
+	var sibling *Node
+	if parent.Left == target {
+		sibling = parent.Right
+	} else {
+		sibling = parent.Left
+	}
+	raiseLevel(sibling)
+	sibling.Parent = parent.Parent
+	switch {
+	case parent.Parent == nil:
+		c.tree.Root = sibling
+	case parent.Parent.Left == parent:
+		parent.Parent.Left = sibling
+	default:
+		parent.Parent.Right = sibling
+	}
+	for n := parent.Parent; n != nil; n = n.Parent {
+		n.Nodehash = nil
+	}
+	out := c.tree.Leaves[:0]
+	for _, n := range c.tree.Leaves {
+		if n != target {
+			out = append(out, n)
+		}
+	}
+	c.tree.Leaves = out
+
+	return c.maybeRebuild()
+}
+
+// raiseLevel decrements the Level of n and, recursively, every node beneath
+// it, after it has been promoted to replace its former parent.
+func raiseLevel(n *Node) {
+	n.Level--
+	if n.Value != nil {
+		n.Value.Level = n.Level
+		return
+	}
+	raiseLevel(n.Left)
+	raiseLevel(n.Right)
+}
+
+// UpdateWeight changes the weight of the entry with the given value.
+// Entry.Hash never depends on Weight, so the change itself invalidates no
+// cached hash; the size of the change is added to the tracked drift, and a
+// full rebuild follows once that drift exceeds Epsilon (see maybeRebuild).
+func (c *CachingTree) UpdateWeight(value []byte, w uint64) error {
+	if w == 0 {
+		return ErrInvalidWeight
+	}
+	for _, e := range c.entries {
+		if bytes.Equal(e.Value, value) {
+			var diff uint64
+			if w > e.Weight {
+				diff = w - e.Weight
+			} else {
+				diff = e.Weight - w
+			}
+			c.total = c.total - e.Weight + w
+			c.drift += diff
+			e.Weight = w
+			return c.maybeRebuild()
+		}
+	}
+	return ErrUnknownEntry
+}
+
+// maybeRebuild triggers a full NewMerkleTree rebuild once the accumulated
+// drift exceeds Epsilon, resetting it afterwards.
+func (c *CachingTree) maybeRebuild() error {
+	if c.total == 0 {
+		return ErrEmptyEntryList
+	}
+	if float64(c.drift) <= c.Epsilon*float64(c.total) {
+		return nil
+	}
+	tree, err := NewMerkleTree(c.entries, c.opts...)
+	if err != nil {
+		return err
+	}
+	c.tree = tree
+	c.drift = 0
+	return nil
+}
+
+// encodedNode is the gob-friendly representation of a Node: it omits the
+// Parent back-pointer (which would make the graph cyclic) and stores the
+// already-computed Nodehash directly, so UnmarshalBinary never needs to
+// re-hash anything.
+type encodedNode struct {
+	Leaf        bool
+	Hash        []byte
+	Level       uint64
+	Value       []byte
+	Weight      uint64
+	Left, Right *encodedNode
+}
+
+type encodedTree struct {
+	HasherKind      uint8
+	DomainSeparated bool
+	MaxLevel        int
+	MaxWeight       uint64
+	Root            *encodedNode
+	Total           uint64
+	Drift           uint64
+	Epsilon         float64
+}
+
+func encodeNode(n *Node) *encodedNode {
+	en := &encodedNode{Hash: n.Nodehash, Level: n.Level}
+	if n.Value != nil {
+		en.Leaf = true
+		en.Value = n.Value.Value
+		en.Weight = n.Value.Weight
+		return en
+	}
+	en.Left = encodeNode(n.Left)
+	en.Right = encodeNode(n.Right)
+	return en
+}
+
+func decodeNode(en *encodedNode, parent *Node, leaves *[]*Node) *Node {
+	n := &Node{Nodehash: en.Hash, Level: en.Level, Parent: parent}
+	if en.Leaf {
+		n.Value = &Entry{Value: en.Value, Weight: en.Weight, Level: en.Level}
+		*leaves = append(*leaves, n)
+		return n
+	}
+	n.Left = decodeNode(en.Left, n, leaves)
+	n.Right = decodeNode(en.Right, n, leaves)
+	return n
+}
+
+func hasherKind(h Hasher) (uint8, error) {
+	switch h.(type) {
+	case Keccak256Hasher:
+		return 0, nil
+	case SHA256Hasher:
+		return 1, nil
+	default:
+		return 0, errors.New("merkletree: MarshalBinary only supports the built-in Hashers")
+	}
+}
+
+func hasherFromKind(kind uint8) (Hasher, error) {
+	switch kind {
+	case 0:
+		return Keccak256Hasher{}, nil
+	case 1:
+		return SHA256Hasher{}, nil
+	default:
+		return nil, errors.New("merkletree: unknown hasher kind in encoded tree")
+	}
+}
+
+// MarshalBinary serializes the cached tree, including every already-computed
+// interior hash, so UnmarshalBinary can restore it without re-hashing.
+func (c *CachingTree) MarshalBinary() ([]byte, error) {
+	kind, err := hasherKind(c.tree.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	c.tree.Hash() // force every interior hash to be cached before encoding
+	et := &encodedTree{
+		HasherKind:      kind,
+		DomainSeparated: c.tree.DomainSeparated,
+		MaxLevel:        c.tree.MaxLevel,
+		MaxWeight:       c.tree.MaxWeight,
+		Root:            encodeNode(c.tree.Root),
+		Total:           c.total,
+		Drift:           c.drift,
+		Epsilon:         c.Epsilon,
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(et); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary restores a tree previously serialized with MarshalBinary.
+func (c *CachingTree) UnmarshalBinary(data []byte) error {
+	et := new(encodedTree)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(et); err != nil {
+		return err
+	}
+	hasher, err := hasherFromKind(et.HasherKind)
+	if err != nil {
+		return err
+	}
+	var leaves []*Node
+	root := decodeNode(et.Root, nil, &leaves)
+
+	entries := make([]*Entry, len(leaves))
+	for i, n := range leaves {
+		entries[i] = n.Value
+	}
+	c.tree = &MerkleTree{
+		Root:            root,
+		Leaves:          leaves,
+		Hasher:          hasher,
+		DomainSeparated: et.DomainSeparated,
+		MaxLevel:        et.MaxLevel,
+		MaxWeight:       et.MaxWeight,
+	}
+	c.entries = entries
+	c.opts = []Option{WithHasher(hasher), WithDomainSeparation(et.DomainSeparated), WithConfig(Config{MaxLevel: et.MaxLevel, MaxWeight: et.MaxWeight})}
+	c.total, c.drift, c.Epsilon = et.Total, et.Drift, et.Epsilon
+	return nil
+}
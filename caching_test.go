@@ -0,0 +1,123 @@
+// Copyright 2019 Gary Rong
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCachingTreeInsertRemove(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 4},
+		{Value: []byte{0x02}, Weight: 3},
+		{Value: []byte{0x03}, Weight: 1},
+	}
+	// NewCachingTree sorts its entries argument in place, so capture the
+	// entries we care about by pointer before constructing the tree rather
+	// than indexing into the slice afterward.
+	e1, e3 := entries[0], entries[2]
+	c, err := NewCachingTree(entries, 1.0) // Epsilon 1.0: never forces a rebuild in this test
+	if err != nil {
+		t.Fatalf("failed to build caching tree: %v", err)
+	}
+	newEntry := &Entry{Value: []byte{0x04}, Weight: 2}
+	if err := c.Insert(newEntry); err != nil {
+		t.Fatalf("failed to insert entry: %v", err)
+	}
+	proof, err := c.Tree().Prove(newEntry)
+	if err != nil {
+		t.Fatalf("failed to prove inserted entry: %v", err)
+	}
+	if _, err := VerifyProof(c.Tree().Hash(), proof, c.Tree().Hasher); err != nil {
+		t.Fatalf("failed to verify inserted entry: %v", err)
+	}
+
+	if err := c.Remove([]byte{0x02}); err != nil {
+		t.Fatalf("failed to remove entry: %v", err)
+	}
+	if _, err := c.Tree().Prove(&Entry{Value: []byte{0x02}}); err != ErrUnknownEntry {
+		t.Fatalf("expected removed entry to be unprovable, got %v", err)
+	}
+	// every remaining entry must still verify against the new root
+	for _, e := range []*Entry{e1, e3, newEntry} {
+		proof, err := c.Tree().Prove(e)
+		if err != nil {
+			t.Fatalf("failed to prove %x: %v", e.Value, err)
+		}
+		if _, err := VerifyProof(c.Tree().Hash(), proof, c.Tree().Hasher); err != nil {
+			t.Fatalf("failed to verify %x: %v", e.Value, err)
+		}
+	}
+}
+
+func TestCachingTreeInsertRejectsLevelBeyondMaxLevel(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 1},
+		{Value: []byte{0x02}, Weight: 1},
+	}
+	c, err := NewCachingTree(entries, 1.0, WithConfig(Config{MaxLevel: 1, MaxWeight: 2}))
+	if err != nil {
+		t.Fatalf("failed to build caching tree: %v", err)
+	}
+	// Both existing leaves already sit at MaxLevel (1); splitting either of
+	// them to make room for a new entry would need level 2.
+	if err := c.Insert(&Entry{Value: []byte{0x03}, Weight: 1}); !errors.Is(err, ErrInvalidWeight) {
+		t.Fatalf("expected ErrInvalidWeight, got %v", err)
+	}
+}
+
+func TestCachingTreeForcesRebuildPastEpsilon(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 10},
+		{Value: []byte{0x02}, Weight: 10},
+	}
+	c, err := NewCachingTree(entries, 0.01)
+	if err != nil {
+		t.Fatalf("failed to build caching tree: %v", err)
+	}
+	before := c.tree
+	if err := c.UpdateWeight([]byte{0x01}, 1000); err != nil {
+		t.Fatalf("failed to update weight: %v", err)
+	}
+	if c.tree == before {
+		t.Fatal("expected a large weight change to trigger a full rebuild")
+	}
+	if c.drift != 0 {
+		t.Fatalf("drift should reset after a rebuild, got %d", c.drift)
+	}
+}
+
+func TestCachingTreeMarshalBinary(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 1},
+		{Value: []byte{0x02}, Weight: 1},
+		{Value: []byte{0x03}, Weight: 2},
+	}
+	c, err := NewCachingTree(entries, 0.1)
+	if err != nil {
+		t.Fatalf("failed to build caching tree: %v", err)
+	}
+	data, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	restored := new(CachingTree)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if !bytes.Equal(restored.Tree().Hash(), c.Tree().Hash()) {
+		t.Fatal("restored tree has a different root hash")
+	}
+	for _, e := range entries {
+		proof, err := restored.Tree().Prove(e)
+		if err != nil {
+			t.Fatalf("failed to prove %x on restored tree: %v", e.Value, err)
+		}
+		if _, err := VerifyProof(restored.Tree().Hash(), proof, restored.Tree().Hasher); err != nil {
+			t.Fatalf("failed to verify %x on restored tree: %v", e.Value, err)
+		}
+	}
+}
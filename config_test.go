@@ -0,0 +1,47 @@
+// Copyright 2019 Gary Rong
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWithConfigRaisesPrecision(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 1},
+		{Value: []byte{0x02}, Weight: 1 << 20},
+	}
+	if _, err := NewMerkleTree(entries); !errors.Is(err, ErrInvalidWeight) {
+		t.Fatalf("expected ErrInvalidWeight with the default MaxLevel, got %v", err)
+	}
+	tree, err := NewMerkleTree(entries, WithConfig(Config{MaxLevel: 32, MaxWeight: 1 << 62}))
+	if err != nil {
+		t.Fatalf("failed to build tree with a wider Config: %v", err)
+	}
+	for _, entry := range entries {
+		proof, err := tree.Prove(entry)
+		if err != nil {
+			t.Fatalf("failed to prove %x: %v", entry.Value, err)
+		}
+		if _, err := VerifyProof(tree.Hash(), proof, tree.Hasher); err != nil {
+			t.Fatalf("failed to verify %x: %v", entry.Value, err)
+		}
+	}
+}
+
+func TestWithConfigRejectsInvalidBounds(t *testing.T) {
+	entries := []*Entry{{Value: []byte{0x01}, Weight: 1}}
+	tests := []Config{
+		{MaxLevel: 0, MaxWeight: 1 << 63},
+		{MaxLevel: 64, MaxWeight: 1 << 63},
+		{MaxLevel: 10, MaxWeight: 3},       // not a power of two
+		{MaxLevel: 40, MaxWeight: 1 << 10}, // smaller than 1<<MaxLevel
+	}
+	for _, cfg := range tests {
+		if _, err := NewMerkleTree(entries, WithConfig(cfg)); !errors.Is(err, ErrInvalidWeight) {
+			t.Fatalf("config %+v: expected ErrInvalidWeight, got %v", cfg, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Gary Rong
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"testing"
+)
+
+func TestDomainSeparation(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 5},
+		{Value: []byte{0x02}, Weight: 3},
+		{Value: []byte{0x03}, Weight: 2},
+		{Value: []byte{0x04}, Weight: 1},
+	}
+	tree, err := NewMerkleTree(entries, WithDomainSeparation(true))
+	if err != nil {
+		t.Fatalf("failed to build tree: %v", err)
+	}
+	if !tree.DomainSeparated {
+		t.Fatal("expected tree to be domain-separated")
+	}
+	for _, entry := range entries {
+		proof, err := tree.Prove(entry)
+		if err != nil {
+			t.Fatalf("failed to prove %x: %v", entry.Value, err)
+		}
+		if !proof.DomainSeparated {
+			t.Fatalf("expected proof of %x to be domain-separated", entry.Value)
+		}
+		if len(proof.Directions) != len(proof.Hashes)-1 {
+			t.Fatalf("expected one direction bit per sibling, got %d directions for %d hashes", len(proof.Directions), len(proof.Hashes))
+		}
+		if _, err := VerifyProof(tree.Hash(), proof, tree.Hasher); err != nil {
+			t.Fatalf("failed to verify proof of %x: %v", entry.Value, err)
+		}
+	}
+}
+
+func TestDomainSeparationRejectsClassicProof(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 1},
+		{Value: []byte{0x02}, Weight: 1},
+	}
+	separated, err := NewMerkleTree(entries, WithDomainSeparation(true))
+	if err != nil {
+		t.Fatalf("failed to build domain-separated tree: %v", err)
+	}
+	classic, err := NewMerkleTree(entries)
+	if err != nil {
+		t.Fatalf("failed to build classic tree: %v", err)
+	}
+	proof, err := classic.Prove(entries[0])
+	if err != nil {
+		t.Fatalf("failed to prove entry: %v", err)
+	}
+	if _, err := VerifyProof(separated.Hash(), proof, separated.Hasher); err == nil {
+		t.Fatal("expected a classic proof to fail verification against a domain-separated root")
+	}
+}
+
+func TestDomainSeparationRejectsMultiProof(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 1},
+		{Value: []byte{0x02}, Weight: 1},
+	}
+	tree, err := NewMerkleTree(entries, WithDomainSeparation(true))
+	if err != nil {
+		t.Fatalf("failed to build tree: %v", err)
+	}
+	if _, err := tree.ProveBatch(entries); err != ErrDomainSeparatedUnsupported {
+		t.Fatalf("expected ErrDomainSeparatedUnsupported, got %v", err)
+	}
+}
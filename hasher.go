@@ -0,0 +1,59 @@
+// Copyright 2019 Gary Rong
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"crypto/sha256"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Hasher abstracts the hash function used to derive leaf and branch hashes
+// inside the merkle tree. It allows consumers outside the Ethereum ecosystem
+// (e.g. Tendermint/Cosmos-SDK style SHA-256 users, Certificate Transparency
+// validators) to plug in their own hash function without pulling in
+// go-ethereum's crypto package.
+type Hasher interface {
+	// Size returns the length, in bytes, of the hash produced by this Hasher.
+	Size() int
+
+	// Hash returns the hash of the concatenation of the given byte slices.
+	Hash(data ...[]byte) []byte
+}
+
+// Keccak256Hasher hashes with Keccak256, the hash function historically used
+// by this package. It's the default Hasher when none is specified.
+type Keccak256Hasher struct{}
+
+// Size implements Hasher.
+func (Keccak256Hasher) Size() int { return 32 }
+
+// Hash implements Hasher.
+func (Keccak256Hasher) Hash(data ...[]byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	for _, b := range data {
+		h.Write(b)
+	}
+	return h.Sum(nil)
+}
+
+// SHA256Hasher hashes with SHA-256, the hash function used by e.g. the
+// Cosmos-SDK and Certificate Transparency.
+type SHA256Hasher struct{}
+
+// Size implements Hasher.
+func (SHA256Hasher) Size() int { return sha256.Size }
+
+// Hash implements Hasher.
+func (SHA256Hasher) Hash(data ...[]byte) []byte {
+	h := sha256.New()
+	for _, b := range data {
+		h.Write(b)
+	}
+	return h.Sum(nil)
+}
+
+// defaultHasher is used by NewMerkleTree and VerifyProof when the caller
+// doesn't specify a Hasher explicitly.
+var defaultHasher Hasher = Keccak256Hasher{}
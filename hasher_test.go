@@ -0,0 +1,45 @@
+// Copyright 2019 Gary Rong
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHashers(t *testing.T) {
+	for _, h := range []Hasher{Keccak256Hasher{}, SHA256Hasher{}} {
+		got := h.Hash([]byte("foo"), []byte("bar"))
+		if len(got) != h.Size() {
+			t.Fatalf("%T: hash length mismatch, got %d want %d", h, len(got), h.Size())
+		}
+		if !bytes.Equal(got, h.Hash([]byte("foo"), []byte("bar"))) {
+			t.Fatalf("%T: hash is not deterministic", h)
+		}
+		if bytes.Equal(got, h.Hash([]byte("bar"), []byte("foo"))) {
+			t.Fatalf("%T: hash doesn't depend on argument order", h)
+		}
+	}
+}
+
+func TestWithHasher(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 1},
+		{Value: []byte{0x02}, Weight: 1},
+	}
+	tree, err := NewMerkleTree(entries, WithHasher(SHA256Hasher{}))
+	if err != nil {
+		t.Fatalf("failed to build tree: %v", err)
+	}
+	if len(tree.Hash()) != (SHA256Hasher{}).Size() {
+		t.Fatalf("root hash has unexpected length %d", len(tree.Hash()))
+	}
+	proof, err := tree.Prove(entries[0])
+	if err != nil {
+		t.Fatalf("failed to prove entry: %v", err)
+	}
+	if _, err := VerifyProof(tree.Hash(), proof, SHA256Hasher{}); err != nil {
+		t.Fatalf("failed to verify proof: %v", err)
+	}
+}
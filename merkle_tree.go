@@ -18,22 +18,30 @@ package merkletree
 
 import (
 	"bytes"
+	"container/heap"
 	"errors"
 	"fmt"
 	"math"
 	"sort"
+)
 
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
+const (
+	// leafPrefix and branchPrefix are the RFC 6962 domain-separation tags
+	// prepended to leaf and interior hashes respectively when a tree is
+	// built with WithDomainSeparation(true).
+	leafPrefix   = byte(0x00)
+	branchPrefix = byte(0x01)
 )
 
-var (
-	// maxLevel indicates the deepest Level the node can be. It means
-	// the minimal weight supported is 1/1024.
-	maxLevel = 10
+const (
+	// DefaultMaxLevel is the MaxLevel a tree is built with if no Config is
+	// supplied via WithConfig. It caps the minimal representable weight at
+	// 1/1024.
+	DefaultMaxLevel = 10
 
-	// maxWeight indicates the denominator used to calculate weight.
-	maxWeight = uint64(1) << 63
+	// DefaultMaxWeight is the MaxWeight a tree is built with if no Config is
+	// supplied via WithConfig.
+	DefaultMaxWeight = uint64(1) << 63
 )
 
 var (
@@ -48,25 +56,29 @@ var (
 
 	// ErrInvalidProof is returned if the provided merkle proof to verify is invalid.
 	ErrInvalidProof = errors.New("invalid merkle proof")
+
+	// ErrDomainSeparatedUnsupported is returned by ProveBatch if the tree was
+	// built with WithDomainSeparation(true): MultiProof's position-indexing
+	// scheme assumes the sorted-concat hashing convention.
+	ErrDomainSeparatedUnsupported = errors.New("compact multi-proof is not supported for domain-separated trees")
 )
 
 // Entry represents the data entry referenced by the merkle tree.
 type Entry struct {
-	Value  []byte  // The corresponding value of this entry
-	Weight uint64  // The initial weight specified by caller
-	Level  uint64  // The level of node which references this entry in the tree
-	bias   float64 // The bias between initial weight and the assigned weight
+	Value  []byte // The corresponding value of this entry
+	Weight uint64 // The initial weight specified by caller
+	Level  uint64 // The level of node which references this entry in the tree
 }
 
-func (s *Entry) Hash() common.Hash { return crypto.Keccak256Hash(s.Value) }
-
-// EntryByBias implements the sort interface to allow sorting a list of entries
-// by their weight bias.
-type EntryByBias []*Entry
-
-func (s EntryByBias) Len() int           { return len(s) }
-func (s EntryByBias) Less(i, j int) bool { return s[i].bias < s[j].bias }
-func (s EntryByBias) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+// Hash returns the hash of this entry, derived with the given Hasher. When
+// domainSeparated is set, the leaf domain tag leafPrefix is prepended, per
+// RFC 6962, to keep leaf and interior hashes from colliding.
+func (s *Entry) Hash(h Hasher, domainSeparated bool) []byte {
+	if domainSeparated {
+		return h.Hash([]byte{leafPrefix}, s.Value)
+	}
+	return h.Hash(s.Value)
+}
 
 // EntryByLevel implements the sort interface to allow sorting a list of entries
 // by their position in the tree in descending order.
@@ -78,105 +90,190 @@ func (s EntryByLevel) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 
 // Node represents a node in merkle tree.
 type Node struct {
-	Nodehash common.Hash // The hash of node.
-	Parent   *Node       // The parent of this node, nil if it's root node.
-	Left     *Node       // The left child of this node
-	Right    *Node       // The right child of this node
-	Level    uint64      // The level of node in this tree
-	Value    *Entry      // The referenced entry by this node, nil if it's not leaf.
+	Nodehash []byte // The hash of node.
+	Parent   *Node  // The parent of this node, nil if it's root node.
+	Left     *Node  // The left child of this node
+	Right    *Node  // The right child of this node
+	Level    uint64 // The level of node in this tree
+	Value    *Entry // The referenced entry by this node, nil if it's not leaf.
 }
 
-// Hash returns the hash of this tree node.
-func (node *Node) Hash() common.Hash {
+// Hash returns the hash of this tree node, deriving it with the given Hasher
+// if it's not already cached.
+//
+// If domainSeparated is false, interior hashes are derived by concatenating
+// the two children with the smaller hash first, which lets VerifyProof
+// reconstruct the tree without any direction bits. If domainSeparated is
+// true, interior hashes are tagged with branchPrefix and combined in the
+// node's actual structural order instead, per RFC 6962; VerifyProof then
+// needs an explicit direction bit per sibling, carried by Proof.Directions.
+func (node *Node) Hash(h Hasher, domainSeparated bool) []byte {
 	// Short circuit if nodehash is already cached.
-	if node.Nodehash != (common.Hash{}) {
+	if len(node.Nodehash) != 0 {
 		return node.Nodehash
 	}
 	// If it's a leaf node, derive the hash by the entry content.
 	if node.Value != nil {
-		node.Nodehash = node.Value.Hash()
+		node.Nodehash = node.Value.Hash(h, domainSeparated)
 		return node.Nodehash
 	}
 	// It's a branch node, derive the hash via two children.
-	leaf, right := node.Left.Hash(), node.Right.Hash() // Both children should never be nil.
-	if bytes.Compare(leaf.Bytes(), right.Bytes()) < 0 {
-		node.Nodehash = crypto.Keccak256Hash(append(leaf.Bytes(), right.Bytes()...))
+	left, right := node.Left.Hash(h, domainSeparated), node.Right.Hash(h, domainSeparated) // Both children should never be nil.
+	if domainSeparated {
+		node.Nodehash = h.Hash([]byte{branchPrefix}, left, right)
+	} else if bytes.Compare(left, right) < 0 {
+		node.Nodehash = h.Hash(left, right)
 	} else {
-		node.Nodehash = crypto.Keccak256Hash(append(right.Bytes(), leaf.Bytes()...))
+		node.Nodehash = h.Hash(right, left)
 	}
 	return node.Nodehash
 }
 
 // String returns the string format of node.
-func (node *Node) String() string {
+func (node *Node) String(h Hasher, domainSeparated bool) string {
 	if node.Value != nil {
 		return fmt.Sprintf("E(%x:%d)", node.Value.Value, node.Value.Level)
 	}
-	return fmt.Sprintf("N(%x) => L.(%s) R.(%s)", node.Hash(), node.Left.String(), node.Right.String())
+	return fmt.Sprintf("N(%x) => L.(%s) R.(%s)", node.Hash(h, domainSeparated), node.Left.String(h, domainSeparated), node.Right.String(h, domainSeparated))
 }
 
 type MerkleTree struct {
-	Roothash common.Hash // The hash of root node, maybe null if we never calculate it.
-	Root     *Node       // The root node of merkle tree.
-	Leaves   []*Node     // Batch of leaves node included in the tree.
+	Roothash        []byte  // The hash of root node, maybe nil if we never calculate it.
+	Root            *Node   // The root node of merkle tree.
+	Leaves          []*Node // Batch of leaves node included in the tree.
+	Hasher          Hasher  // The hash function used to derive node hashes, defaults to Keccak256Hasher.
+	DomainSeparated bool    // Whether leaf/interior hashes use RFC 6962 domain separation instead of sorted-concat.
+	MaxLevel        int     // The deepest Level an entry's weight can be normalized down to, defaults to DefaultMaxLevel.
+	MaxWeight       uint64  // The denominator used to calculate weight, defaults to DefaultMaxWeight.
+}
+
+// Config customizes the precision NewMerkleTree uses when normalizing entry
+// weights. MaxLevel bounds the smallest representable weight share, 1/2^MaxLevel,
+// and must be between 1 and 63. MaxWeight is the total weight every entry's
+// normalized weight is measured against, and must be a power of two no
+// smaller than 1<<MaxLevel.
+//
+// The default, applied if WithConfig isn't supplied, is MaxLevel: 10,
+// MaxWeight: 1<<63, which caps the smallest representable weight at 1/1024.
+// Raising MaxLevel lets entries with a much wider spread of weights -
+// tens of thousands of stakers, or a long-tailed distribution - be
+// represented without ErrInvalidWeight rejecting the smallest of them.
+type Config struct {
+	MaxLevel  int
+	MaxWeight uint64
+}
+
+// Option configures optional parameters accepted by NewMerkleTree.
+type Option func(*MerkleTree)
+
+// WithHasher sets the Hasher used to derive leaf and branch hashes.
+// Keccak256Hasher is used if this option isn't supplied.
+func WithHasher(h Hasher) Option {
+	return func(t *MerkleTree) { t.Hasher = h }
+}
+
+// WithDomainSeparation enables RFC 6962 style domain-separated hashing:
+// leaves are hashed as H(leafPrefix || value) and interior nodes as
+// H(branchPrefix || left || right), in the tree's actual structural order.
+// This is required for interop with existing Certificate Transparency,
+// Cosmos-SDK and ssz verifiers, and closes the second-preimage attack the
+// default sorted-concat scheme is vulnerable to, at the cost of one extra
+// direction bit per proof step. See Proof.Directions and VerifyProof.
+func WithDomainSeparation(enabled bool) Option {
+	return func(t *MerkleTree) { t.DomainSeparated = enabled }
+}
+
+// WithConfig sets the weight-precision bounds NewMerkleTree normalizes
+// entries against. See Config.
+func WithConfig(cfg Config) Option {
+	return func(t *MerkleTree) { t.MaxLevel, t.MaxWeight = cfg.MaxLevel, cfg.MaxWeight }
 }
 
 // NewMerkleTree constructs a merkle tree with given entries.
-func NewMerkleTree(entries []*Entry) (*MerkleTree, error) {
+func NewMerkleTree(entries []*Entry, opts ...Option) (*MerkleTree, error) {
 	if len(entries) == 0 {
 		return nil, ErrEmptyEntryList
 	}
+	tree := &MerkleTree{Hasher: defaultHasher, MaxLevel: DefaultMaxLevel, MaxWeight: DefaultMaxWeight}
+	for _, opt := range opts {
+		opt(tree)
+	}
+	if tree.MaxLevel <= 0 || tree.MaxLevel > 63 {
+		return nil, fmt.Errorf("%w: MaxLevel %d is out of range, must be between 1 and 63", ErrInvalidWeight, tree.MaxLevel)
+	}
+	if tree.MaxWeight&(tree.MaxWeight-1) != 0 || tree.MaxWeight>>uint(tree.MaxLevel) == 0 {
+		return nil, fmt.Errorf("%w: MaxWeight %d must be a power of two no smaller than 1<<MaxLevel", ErrInvalidWeight, tree.MaxWeight)
+	}
+	maxLevel, maxWeight := tree.MaxLevel, tree.MaxWeight
+
 	// Verify the validity of the given entries.
 	var sum, totalWeight uint64
 	for _, entry := range entries {
 		if entry.Weight == 0 {
-			return nil, ErrInvalidWeight
+			return nil, fmt.Errorf("%w: entry %x has zero weight", ErrInvalidWeight, entry.Value)
 		}
 		sum += entry.Weight
 	}
+	bumpable := make([]*Entry, 0, len(entries))
 	for _, entry := range entries {
-		l := math.Log2(float64(sum) / float64(entry.Weight))
-		c := math.Ceil(l)
-		entry.bias = l - c + 1
+		c := math.Ceil(math.Log2(float64(sum) / float64(entry.Weight)))
 		if int(c) > maxLevel {
-			return nil, ErrInvalidWeight
+			return nil, fmt.Errorf("%w: entry %x needs level %d which exceeds MaxLevel %d", ErrInvalidWeight, entry.Value, int(c), maxLevel)
 		}
-		totalWeight += maxWeight >> int(c)
 		entry.Level = uint64(c)
+		totalWeight += maxWeight >> uint(c)
+		if entry.Level > 0 {
+			bumpable = append(bumpable, entry)
+		}
 	}
-	sort.Sort(EntryByBias(entries))
-
-	// Bump the weight of entry if we can't reach 100%
-	shift := entries
-	for totalWeight < maxWeight && len(shift) > 0 {
-		var limit int
-		for index, entry := range shift {
-			addWeight := maxWeight >> entry.Level
-			if totalWeight+addWeight <= maxWeight {
-				totalWeight += addWeight
-				entry.Level -= 1
-				if index != limit {
-					shift[limit], shift[index] = shift[index], shift[limit]
-				}
-				limit += 1
-				if totalWeight == maxWeight {
-					break
-				}
-			}
+
+	// Bump the weight of entries if we can't reach 100%, cheapest bump first.
+	// bumpHeap keeps this to O(log N) per bump instead of rescanning every
+	// remaining candidate once per level, as a linear scan would.
+	h := bumpHeap(bumpable)
+	heap.Init(&h)
+	for totalWeight < maxWeight && h.Len() > 0 {
+		entry := h[0]
+		addWeight := maxWeight >> entry.Level
+		if totalWeight+addWeight > maxWeight {
+			// h is a min-heap on addWeight, so nothing cheaper remains either.
+			break
+		}
+		heap.Pop(&h)
+		totalWeight += addWeight
+		entry.Level--
+		if entry.Level > 0 {
+			heap.Push(&h, entry)
 		}
-		shift = shift[:limit]
 	}
 	sort.Sort(EntryByLevel(entries))
 
 	// Start to build the merkle tree, short circuit if there is only 1 entry.
-	root, leaves, err := newTree(entries)
+	root, leaves, err := newTree(entries, maxWeight)
 	if err != nil {
 		return nil, err
 	}
-	return &MerkleTree{Root: root, Leaves: leaves}, nil
+	tree.Root, tree.Leaves = root, leaves
+	return tree, nil
 }
 
-func newTree(entries []*Entry) (*Node, []*Node, error) {
+// bumpHeap is a min-heap of entries ordered by the weight their next bump
+// would add, maxWeight>>Level ascending, i.e. Level descending.
+type bumpHeap []*Entry
+
+func (h bumpHeap) Len() int            { return len(h) }
+func (h bumpHeap) Less(i, j int) bool  { return h[i].Level > h[j].Level }
+func (h bumpHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *bumpHeap) Push(x interface{}) { *h = append(*h, x.(*Entry)) }
+func (h *bumpHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	*h = old[:n-1]
+	return entry
+}
+
+func newTree(entries []*Entry, maxWeight uint64) (*Node, []*Node, error) {
 	// Short circuit if we only have 1 entry, return it as the root node
 	// of sub tree.
 	if len(entries) == 1 {
@@ -219,7 +316,7 @@ func newTree(entries []*Entry) (*Node, []*Node, error) {
 					break
 				}
 			}
-			right, subLeaves, err := newTree(entries[i : j+1])
+			right, subLeaves, err := newTree(entries[i:j+1], maxWeight)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -234,12 +331,30 @@ func newTree(entries []*Entry) (*Node, []*Node, error) {
 }
 
 // Hash calculates the root hash of merkle tree.
-func (t *MerkleTree) Hash() common.Hash {
-	return t.Root.Hash()
+func (t *MerkleTree) Hash() []byte {
+	return t.Root.Hash(t.Hasher, t.DomainSeparated)
+}
+
+// Proof is a merkle proof of inclusion for a single entry.
+type Proof struct {
+	// Hashes holds the proven leaf's own hash followed by one sibling hash
+	// per level, from the leaf up to the root.
+	Hashes [][]byte
+
+	// Directions[i] reports whether the sibling Hashes[i+1] is the left
+	// child at that step, i.e. the proven entry's side is the right child.
+	// It's only populated, and only consulted by VerifyProof, when
+	// DomainSeparated is set: the default sorted-concat scheme doesn't need
+	// direction bits at all.
+	Directions []bool
+
+	// DomainSeparated reports which of the two hashing/combination
+	// conventions this proof was built with; see WithDomainSeparation.
+	DomainSeparated bool
 }
 
 // Prove constructs a merkle proof for the specified entry.
-func (t *MerkleTree) Prove(e *Entry) ([]common.Hash, error) {
+func (t *MerkleTree) Prove(e *Entry) (*Proof, error) {
 	var n *Node
 	for _, leaf := range t.Leaves {
 		if bytes.Equal(leaf.Value.Value, e.Value) {
@@ -250,20 +365,23 @@ func (t *MerkleTree) Prove(e *Entry) ([]common.Hash, error) {
 	if n == nil {
 		return nil, ErrUnknownEntry
 	}
-	var hashes []common.Hash
-	hashes = append(hashes, n.Hash())
-	for {
-		if n.Parent == nil {
-			break
-		}
+	proof := &Proof{DomainSeparated: t.DomainSeparated}
+	proof.Hashes = append(proof.Hashes, n.Hash(t.Hasher, t.DomainSeparated))
+	for n.Parent != nil {
+		var sibling *Node
+		var siblingIsLeft bool
 		if n.Parent.Left == n {
-			hashes = append(hashes, n.Parent.Right.Hash())
+			sibling = n.Parent.Right
 		} else {
-			hashes = append(hashes, n.Parent.Left.Hash())
+			sibling, siblingIsLeft = n.Parent.Left, true
+		}
+		proof.Hashes = append(proof.Hashes, sibling.Hash(t.Hasher, t.DomainSeparated))
+		if t.DomainSeparated {
+			proof.Directions = append(proof.Directions, siblingIsLeft)
 		}
 		n = n.Parent
 	}
-	return hashes, nil
+	return proof, nil
 }
 
 // VerifyProof verifies the provided merkle proof is valid or not.
@@ -292,29 +410,50 @@ func (t *MerkleTree) Prove(e *Entry) ([]common.Hash, error) {
 // node to target node. Like the position of e2 is 010 => 2, while
 // for e3 the position is 011 => 3. Combine with the level node is
 // in, we can calculate the probability range represented by this entry.
-func VerifyProof(root common.Hash, proof []common.Hash) (uint64, error) {
-	if len(proof) == 0 {
+//
+// VerifyProof accepts proofs from both hashing conventions, selecting the
+// combination rule based on proof.DomainSeparated: the default sorted-concat
+// scheme derives directions from comparing hashes, while a domain-separated
+// proof carries them explicitly in proof.Directions.
+//
+// The hasher must match the one used to build the tree the proof was
+// derived from; if hasher is nil, Keccak256Hasher is assumed.
+func VerifyProof(root []byte, proof *Proof, hasher Hasher) (uint64, error) {
+	if hasher == nil {
+		hasher = defaultHasher
+	}
+	if proof == nil || len(proof.Hashes) == 0 {
 		return 0, ErrInvalidProof
 	}
-	if len(proof) == 1 {
-		if root == proof[0] {
+	if len(proof.Hashes) == 1 {
+		if bytes.Equal(root, proof.Hashes[0]) {
 			return 0, nil
 		}
 		return 0, ErrInvalidProof
 	}
+	if proof.DomainSeparated && len(proof.Directions) != len(proof.Hashes)-1 {
+		return 0, ErrInvalidProof
+	}
 	var (
-		current = proof[0]
+		current = proof.Hashes[0]
 		pos     uint64
 	)
-	for i := 1; i < len(proof); i += 1 {
-		if bytes.Compare(current.Bytes(), proof[i].Bytes()) < 0 {
-			current = crypto.Keccak256Hash(append(current.Bytes(), proof[i].Bytes()...))
-		} else {
-			pos = pos + 1<<(i-1)
-			current = crypto.Keccak256Hash(append(proof[i].Bytes(), current.Bytes()...))
+	for i := 1; i < len(proof.Hashes); i += 1 {
+		sibling := proof.Hashes[i]
+		switch {
+		case proof.DomainSeparated && proof.Directions[i-1]: // sibling is the left child
+			pos |= 1 << (i - 1)
+			current = hasher.Hash([]byte{branchPrefix}, sibling, current)
+		case proof.DomainSeparated:
+			current = hasher.Hash([]byte{branchPrefix}, current, sibling)
+		case bytes.Compare(current, sibling) < 0:
+			current = hasher.Hash(current, sibling)
+		default:
+			pos |= 1 << (i - 1)
+			current = hasher.Hash(sibling, current)
 		}
 	}
-	if root != current {
+	if !bytes.Equal(root, current) {
 		return 0, ErrInvalidProof
 	}
 	return pos, nil
@@ -322,5 +461,5 @@ func VerifyProof(root common.Hash, proof []common.Hash) (uint64, error) {
 
 // String returns the string format of tree which helps to debug.
 func (t *MerkleTree) String() string {
-	return t.Root.String()
+	return t.Root.String(t.Hasher, t.DomainSeparated)
 }
@@ -47,7 +47,7 @@ func (t *merkleTreeTest) run() bool {
 			t.err = err
 			return false
 		}
-		pos, err := VerifyProof(tree.Root.Hash(), proof)
+		pos, err := VerifyProof(tree.Root.Hash(tree.Hasher, tree.DomainSeparated), proof, tree.Hasher)
 		if err != nil {
 			t.err = err
 			return false
@@ -123,7 +123,7 @@ func ExampleMerkleTree() {
 	if err != nil {
 		fmt.Println(err)
 	}
-	pos, err := VerifyProof(tree.Hash(), proof)
+	pos, err := VerifyProof(tree.Hash(), proof, nil)
 	if err != nil {
 		fmt.Println(err)
 	}
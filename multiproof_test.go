@@ -0,0 +1,116 @@
+// Copyright 2019 Gary Rong
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"testing"
+)
+
+func TestProveBatch(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 5},
+		{Value: []byte{0x02}, Weight: 5},
+		{Value: []byte{0x03}, Weight: 3},
+		{Value: []byte{0x04}, Weight: 2},
+		{Value: []byte{0x05}, Weight: 1},
+	}
+	tree, err := NewMerkleTree(entries)
+	if err != nil {
+		t.Fatalf("failed to build tree: %v", err)
+	}
+	proven := []*Entry{entries[0], entries[2], entries[4]}
+	proof, err := tree.ProveBatch(proven)
+	if err != nil {
+		t.Fatalf("failed to build multi proof: %v", err)
+	}
+	leaves := make([][]byte, len(proven))
+	for i, e := range proven {
+		leaves[i] = e.Hash(tree.Hasher, false)
+	}
+	if err := VerifyMultiProof(tree.Hash(), leaves, proof, tree.Hasher); err != nil {
+		t.Fatalf("failed to verify multi proof: %v", err)
+	}
+
+	// A compact proof should always be smaller than proving every entry one
+	// by one, since shared interior hashes are only included once.
+	var separate int
+	for _, e := range proven {
+		p, err := tree.Prove(e)
+		if err != nil {
+			t.Fatalf("failed to build proof: %v", err)
+		}
+		separate += len(p.Hashes)
+	}
+	if len(proof.Hashes) >= separate {
+		t.Fatalf("multi proof (%d hashes) isn't more compact than %d individual proof hashes", len(proof.Hashes), separate)
+	}
+
+	// Tampering with a leaf hash must invalidate the proof.
+	leaves[0][0] ^= 0xff
+	if err := VerifyMultiProof(tree.Hash(), leaves, proof, tree.Hasher); err == nil {
+		t.Fatal("expected tampered multi proof to fail verification")
+	}
+}
+
+// TestVerifyMultiProofRejectsSwappedPositions guards against a prover
+// relabeling which claimed position belongs to which proven leaf. Because
+// the sorted-concat combination rule is symmetric, swapping two real
+// siblings' Positions still lets the same hashes fold up to the true root;
+// VerifyMultiProof must catch this by deriving each leaf's real position
+// from the hash chain instead of trusting the label.
+func TestVerifyMultiProofRejectsSwappedPositions(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 1},
+		{Value: []byte{0x02}, Weight: 1},
+		{Value: []byte{0x03}, Weight: 1},
+		{Value: []byte{0x04}, Weight: 1},
+	}
+	tree, err := NewMerkleTree(entries)
+	if err != nil {
+		t.Fatalf("failed to build tree: %v", err)
+	}
+	// entries[0] and entries[1] are siblings (same Level, adjacent Position).
+	proven := []*Entry{entries[0], entries[1]}
+	proof, err := tree.ProveBatch(proven)
+	if err != nil {
+		t.Fatalf("failed to build multi proof: %v", err)
+	}
+	if proof.Levels[0] != proof.Levels[1] {
+		t.Fatalf("test setup expects siblings, got levels %v", proof.Levels)
+	}
+	leaves := make([][]byte, len(proven))
+	for i, e := range proven {
+		leaves[i] = e.Hash(tree.Hasher, false)
+	}
+	proof.Positions[0], proof.Positions[1] = proof.Positions[1], proof.Positions[0]
+	if err := VerifyMultiProof(tree.Hash(), leaves, proof, tree.Hasher); err == nil {
+		t.Fatal("expected multi proof with swapped positions to fail verification")
+	}
+}
+
+func TestProveBatchAllEntries(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 1},
+		{Value: []byte{0x02}, Weight: 1},
+		{Value: []byte{0x03}, Weight: 1},
+	}
+	tree, err := NewMerkleTree(entries)
+	if err != nil {
+		t.Fatalf("failed to build tree: %v", err)
+	}
+	proof, err := tree.ProveBatch(entries)
+	if err != nil {
+		t.Fatalf("failed to build multi proof: %v", err)
+	}
+	if len(proof.Hashes) != 0 {
+		t.Fatalf("proving every entry should need no extra hashes, got %d", len(proof.Hashes))
+	}
+	leaves := make([][]byte, len(entries))
+	for i, e := range entries {
+		leaves[i] = e.Hash(tree.Hasher, false)
+	}
+	if err := VerifyMultiProof(tree.Hash(), leaves, proof, tree.Hasher); err != nil {
+		t.Fatalf("failed to verify multi proof: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 Gary Rong
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"encoding/binary"
+	"math/rand"
+)
+
+// Sample draws a single entry from the tree with probability proportional to
+// its assigned weight, using r as the source of randomness. It returns the
+// sampled entry together with a merkle proof of its inclusion and its
+// position, so a verifier can re-run VerifyProof to confirm both that the
+// entry is included in the tree and that the returned position falls inside
+// the entry's probability range, [pos/2^Level, (pos+1)/2^Level).
+func (t *MerkleTree) Sample(r *rand.Rand) (*Entry, *Proof, uint64, error) {
+	return t.sample(r.Uint64() % t.MaxWeight)
+}
+
+// SampleFromSeed is the deterministic counterpart of Sample: the drawn entry
+// is derived from seed instead of an external random source, so two callers
+// hashing the same seed against the same tree always agree on the same
+// sample. This is useful to turn the tree into a verifiable lottery, keyed
+// off of e.g. a randomness beacon.
+func (t *MerkleTree) SampleFromSeed(seed []byte) (*Entry, *Proof, uint64, error) {
+	digest := t.Hasher.Hash(seed)
+	return t.sample(binary.BigEndian.Uint64(digest[:8]) % t.MaxWeight)
+}
+
+// sample walks down the tree from the root, picking at each branch node the
+// child whose probability range contains w. Because a node's own weight is
+// t.MaxWeight>>node.Level, the left child's weight alone is enough to decide
+// which side w falls into.
+func (t *MerkleTree) sample(w uint64) (*Entry, *Proof, uint64, error) {
+	node := t.Root
+	for node.Value == nil {
+		leftWeight := t.MaxWeight >> node.Left.Level
+		if w < leftWeight {
+			node = node.Left
+		} else {
+			w -= leftWeight
+			node = node.Right
+		}
+	}
+	proof, err := t.Prove(node.Value)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	pos, err := VerifyProof(t.Hash(), proof, t.Hasher)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return node.Value, proof, pos, nil
+}
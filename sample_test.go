@@ -0,0 +1,63 @@
+// Copyright 2019 Gary Rong
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSample(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 5},
+		{Value: []byte{0x02}, Weight: 3},
+		{Value: []byte{0x03}, Weight: 2},
+		{Value: []byte{0x04}, Weight: 1},
+	}
+	tree, err := NewMerkleTree(entries)
+	if err != nil {
+		t.Fatalf("failed to build tree: %v", err)
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		entry, proof, pos, err := tree.Sample(r)
+		if err != nil {
+			t.Fatalf("failed to sample entry: %v", err)
+		}
+		got, err := VerifyProof(tree.Hash(), proof, tree.Hasher)
+		if err != nil {
+			t.Fatalf("sampled proof doesn't verify: %v", err)
+		}
+		if got != pos {
+			t.Fatalf("position mismatch, got %d want %d", got, pos)
+		}
+		d := uint64(1) << entry.Level
+		if pos >= d {
+			t.Fatalf("position %d outside of probability range for level %d", pos, entry.Level)
+		}
+	}
+}
+
+func TestSampleFromSeed(t *testing.T) {
+	entries := []*Entry{
+		{Value: []byte{0x01}, Weight: 1},
+		{Value: []byte{0x02}, Weight: 1},
+		{Value: []byte{0x03}, Weight: 1},
+	}
+	tree, err := NewMerkleTree(entries)
+	if err != nil {
+		t.Fatalf("failed to build tree: %v", err)
+	}
+	entry1, _, _, err := tree.SampleFromSeed([]byte("beacon-round-1"))
+	if err != nil {
+		t.Fatalf("failed to sample entry: %v", err)
+	}
+	entry2, _, _, err := tree.SampleFromSeed([]byte("beacon-round-1"))
+	if err != nil {
+		t.Fatalf("failed to sample entry: %v", err)
+	}
+	if string(entry1.Value) != string(entry2.Value) {
+		t.Fatalf("same seed produced different samples: %x vs %x", entry1.Value, entry2.Value)
+	}
+}